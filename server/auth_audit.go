@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuthDecision is the outcome of an authentication attempt, as recorded
+// by an AuthAuditor.
+type AuthDecision string
+
+const (
+	AuthDecisionAllow AuthDecision = "allow"
+	AuthDecisionDeny  AuthDecision = "deny"
+)
+
+// AuthEvent describes a single authentication decision, independent of
+// which Auth implementation produced it.
+type AuthEvent struct {
+	Timestamp      time.Time    `json:"timestamp"`
+	RemoteAddr     string       `json:"remote_addr,omitempty"`
+	ClientID       uint64       `json:"client_id,omitempty"`
+	AuthMethod     string       `json:"auth_method"`
+	TokenKid       string       `json:"token_kid,omitempty"`
+	SubjectClaim   string       `json:"subject_claim,omitempty"`
+	Issuer         string       `json:"issuer,omitempty"`
+	Decision       AuthDecision `json:"decision"`
+	Reason         string       `json:"reason,omitempty"`
+	MatchedAccount string       `json:"matched_account,omitempty"`
+}
+
+// AuthAuditor is a pluggable sink for AuthEvents. Auth implementations
+// call Record on every accept/deny decision; the default NATSAuthAuditor
+// publishes them as JetStream-consumable $SYS events, but deployments
+// can supply their own (e.g. to forward straight to an external log
+// pipeline).
+type AuthAuditor interface {
+	Record(ctx context.Context, event AuthEvent)
+}
+
+// noopAuthAuditor discards every event. Nothing in this package
+// constructs it: bearerAuthFactory installs NewNATSAuthAuditor whenever
+// BearerAuthOptions.Auditor is nil, so audit events are never silently
+// unavailable.
+type noopAuthAuditor struct{}
+
+func (noopAuthAuditor) Record(context.Context, AuthEvent) {}
+
+const (
+	defaultAuthAuditSubjectFmt = "$SYS.ACCOUNT.%s.AUTH"
+	defaultAuthAuditFallback   = 256
+	unknownAuditAccount        = "_unknown_"
+)
+
+// NATSAuthAuditor is the default AuthAuditor. It publishes each AuthEvent
+// as JSON on subjectFmt (formatted with the matched account name, or
+// unknownAuditAccount when none was resolved) using the server's
+// internal system-account sendq, so operators can mirror, alert on, or
+// JetStream-persist the audit stream without an external logging agent.
+//
+// When the system account isn't wired up (or publishing otherwise
+// fails), events are kept in a small bounded in-memory ring instead of
+// being dropped outright; FallbackEvents drains it for diagnostics.
+type NATSAuthAuditor struct {
+	server     *Server
+	subjectFmt string
+	sampleRate float64
+
+	mu       sync.Mutex
+	fallback []AuthEvent
+	maxBuf   int
+}
+
+// NewNATSAuthAuditor builds the default auditor for s, configured via
+// JWT_AUTH_AUDIT_SUBJECT (a %s-templated subject, default
+// "$SYS.ACCOUNT.%s.AUTH"), JWT_AUTH_AUDIT_SAMPLE_RATE (0.0-1.0, default
+// 1.0) and JWT_AUTH_AUDIT_FALLBACK_SIZE (default 256).
+func NewNATSAuthAuditor(s *Server) *NATSAuthAuditor {
+	a := &NATSAuthAuditor{
+		server:     s,
+		subjectFmt: defaultAuthAuditSubjectFmt,
+		sampleRate: 1.0,
+		maxBuf:     defaultAuthAuditFallback,
+	}
+
+	if raw := os.Getenv("JWT_AUTH_AUDIT_SUBJECT"); raw != "" {
+		a.subjectFmt = raw
+	}
+	if raw := os.Getenv("JWT_AUTH_AUDIT_SAMPLE_RATE"); raw != "" {
+		if rate, err := strconv.ParseFloat(raw, 64); err == nil && rate >= 0 && rate <= 1 {
+			a.sampleRate = rate
+		} else {
+			s.Warnf("invalid JWT_AUTH_AUDIT_SAMPLE_RATE %q, using default", raw)
+		}
+	}
+	if raw := os.Getenv("JWT_AUTH_AUDIT_FALLBACK_SIZE"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			a.maxBuf = n
+		} else {
+			s.Warnf("invalid JWT_AUTH_AUDIT_FALLBACK_SIZE %q, using default", raw)
+		}
+	}
+
+	return a
+}
+
+// Record implements AuthAuditor. Sampling only ever thins allow events;
+// a deny is always recorded, since a deny is already rare relative to
+// the allow traffic sampling exists to reduce, and is exactly what an
+// operator investigating a spike in rejected connections needs the
+// audit trail to retain.
+func (a *NATSAuthAuditor) Record(ctx context.Context, event AuthEvent) {
+	if event.Decision != AuthDecisionDeny && a.sampleRate < 1 && rand.Float64() > a.sampleRate {
+		return
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	account := event.MatchedAccount
+	if account == "" {
+		account = unknownAuditAccount
+	}
+	subject := fmt.Sprintf(a.subjectFmt, account)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		a.server.Warnf("failed to marshal auth audit event: %s", err.Error())
+		return
+	}
+
+	sysAcc := a.server.SystemAccount()
+	if sysAcc == nil {
+		a.bufferFallback(event)
+		return
+	}
+
+	if err := a.server.sendInternalAccountMsg(sysAcc, subject, payload); err != nil {
+		a.server.Warnf("failed to publish auth audit event on %s: %s", subject, err.Error())
+		a.bufferFallback(event)
+	}
+}
+
+// bufferFallback appends event to the bounded in-memory ring, evicting
+// the oldest entry once maxBuf is reached.
+func (a *NATSAuthAuditor) bufferFallback(event AuthEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.fallback) >= a.maxBuf {
+		a.fallback = a.fallback[1:]
+	}
+	a.fallback = append(a.fallback, event)
+}
+
+// FallbackEvents returns (and clears) any AuthEvents that could not be
+// published, either because the system account wasn't available or
+// because publishing failed.
+func (a *NATSAuthAuditor) FallbackEvents() []AuthEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	events := a.fallback
+	a.fallback = nil
+	return events
+}
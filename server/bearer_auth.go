@@ -1,38 +1,226 @@
 package server
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"math/big"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	natsjwt "github.com/nats-io/jwt/v2"
 )
 
+// defaultJWKSRefreshInterval governs how often remote JWKS documents are
+// re-fetched so that keys rotated by the IdP are picked up without a
+// restart. It is only the starting interval: a JWKS response's
+// Cache-Control max-age, if present, replaces it, whether shorter or
+// longer (see setRefreshInterval).
+const defaultJWKSRefreshInterval = 5 * time.Minute
+
+// negativeCacheTTL bounds how long an unknown kid is remembered as
+// unknown before another on-demand refresh will be attempted for it,
+// so a client hammering us with a bogus kid can't force a refresh on
+// every single connection attempt.
+const negativeCacheTTL = 30 * time.Second
+
+// minForcedRefreshInterval debounces on-demand refreshes triggered by
+// unknown kids: concurrent connections racing on the same unknown kid
+// share a single refresh instead of each triggering their own.
+const minForcedRefreshInterval = 5 * time.Second
+
+// oidcDiscoveryDoc is the subset of the OpenID Connect discovery document
+// (RFC/OIDC "/.well-known/openid-configuration") that we care about.
+type oidcDiscoveryDoc struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// jwk is a single entry of a JSON Web Key Set, broad enough to cover the
+// RSA ("RSA"), ECDSA ("EC") and Ed25519 ("OKP") key types we verify.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwksDoc struct {
+	Keys []jwk `json:"keys"`
+}
+
+// verifierKey pairs a public key with the JWT signing method it is
+// permitted to verify, so that a compromised or misconfigured key can't
+// be replayed under a different algorithm (alg-confusion). issuer binds
+// the key to the issuer it was discovered from (empty for the legacy
+// static key), so that a token can't claim to be from issuer A while
+// actually being verified by a key that only ever belonged to issuer B.
+type verifierKey struct {
+	key    crypto.PublicKey
+	alg    string
+	issuer string
+}
+
+// BearerAuthOptions is the subset of the server's configured Options
+// governing OIDC/JWKS-backed bearer auth (Options.BearerAuth in the
+// full server config). It's its own type, rather than flat arguments to
+// bearerAuthFactory, so that it participates in config reload like any
+// other Options field and so tests can exercise bearerAuthFactory
+// directly with a literal instead of mutating process environment.
+//
+// JWT_SIGNER_PUBLIC_KEY is the only key source read from the
+// environment, kept for backwards compatibility with deployments that
+// configured the legacy single static key that way before this type
+// existed. The claim mapping layer (JWT_CLAIM_MAPPINGS,
+// JWT_ACCOUNT_TEMPLATE, JWT_SUBJECT_ALLOW_TEMPLATES,
+// JWT_SUBJECT_DENY_TEMPLATES) and the default NATSAuthAuditor
+// (JWT_AUTH_AUDIT_*) are configured only through environment variables;
+// this type has no fields for them.
+type BearerAuthOptions struct {
+	// Issuers are the OIDC issuer URLs polled for discovery + JWKS
+	// rotation.
+	Issuers []string
+	// JWKSURIs are JWKS endpoints configured directly, bypassing
+	// discovery.
+	JWKSURIs []string
+	// Audiences, when non-empty, is the allow-list a token's `aud` claim
+	// must intersect with.
+	Audiences []string
+	// JWKSRefreshInterval overrides defaultJWKSRefreshInterval when
+	// nonzero.
+	JWKSRefreshInterval time.Duration
+	// Auditor, if non-nil, replaces the default NATSAuthAuditor as the
+	// sink for accept/deny decisions. This is what makes AuthAuditor's
+	// "pluggable" doc comment true: without it, every deployment was
+	// stuck with NATSAuthAuditor regardless of what AuthAuditor's
+	// interface allowed.
+	Auditor AuthAuditor
+}
+
 type BearerAuth struct {
 	server *Server
-	jwks   map[string]*rsa.PublicKey
+
+	mu   sync.RWMutex
+	jwks map[string]*verifierKey
+
+	// issuers holds the set of OIDC issuer URLs that are polled for
+	// discovery + JWKS rotation. jwksURIs are JWKS endpoints configured
+	// directly, bypassing discovery. audiences, when non-empty, is the
+	// allow-list a token's `aud` claim must intersect with.
+	issuers         []string
+	jwksURIs        []string
+	audiences       []string
+	httpClient      *http.Client
+	refreshInterval time.Duration
+
+	// negCache remembers kids that were looked up and not found, so a
+	// storm of requests carrying the same unknown kid triggers at most
+	// one on-demand refresh every minForcedRefreshInterval rather than
+	// one per connection. Guarded by mu.
+	negCache          map[string]time.Time
+	lastForcedRefresh time.Time
+
+	// claim-to-account/subject mapping layer; see bearer_auth_mapping.go.
+	claimMappings       []claimMapping
+	accountTemplate     string
+	allowTemplates      []string
+	denyTemplates       []string
+	synthesizedAccounts int
+
+	// auditor records every accept/deny decision; see auth_audit.go.
+	auditor AuthAuditor
 }
 
-func bearerAuthFactory(s *Server) (*BearerAuth, error) {
+// bearerAuthFactory builds the BearerAuth for s from opts (normally
+// s.getOpts().BearerAuth). JWT_SIGNER_PUBLIC_KEY is read from the
+// environment for the legacy single static key; the claim mapping layer
+// and the default auditor also read their settings from the environment.
+func bearerAuthFactory(s *Server, opts BearerAuthOptions) (*BearerAuth, error) {
 	auth := &BearerAuth{
-		server: s,
-		jwks:   map[string]*rsa.PublicKey{},
+		server:          s,
+		jwks:            map[string]*verifierKey{},
+		negCache:        map[string]time.Time{},
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+		issuers:         opts.Issuers,
+		jwksURIs:        opts.JWKSURIs,
+		audiences:       opts.Audiences,
+		refreshInterval: defaultJWKSRefreshInterval,
 	}
-	err := auth.readPublicKey()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read JWT_SIGNER_PUBLIC_KEY from environment")
+	if opts.JWKSRefreshInterval > 0 {
+		auth.refreshInterval = opts.JWKSRefreshInterval
+	}
+
+	// Legacy single static key, kept for backwards compatibility with
+	// deployments that only ever used JWT_SIGNER_PUBLIC_KEY, from before
+	// OIDC/JWKS support (and BearerAuthOptions) existed.
+	if os.Getenv("JWT_SIGNER_PUBLIC_KEY") != "" {
+		if err := auth.readPublicKey(); err != nil {
+			return nil, fmt.Errorf("failed to read JWT_SIGNER_PUBLIC_KEY from environment")
+		}
+	}
+
+	if len(auth.issuers) == 0 && len(auth.jwksURIs) == 0 && len(auth.jwks) == 0 {
+		return nil, fmt.Errorf("bearer auth requires JWT_SIGNER_PUBLIC_KEY, or Issuers/JWKSURIs in BearerAuthOptions")
+	}
+
+	if err := auth.configureClaimMapping(); err != nil {
+		return nil, err
+	}
+
+	auth.auditor = opts.Auditor
+	if auth.auditor == nil {
+		auth.auditor = NewNATSAuthAuditor(s)
 	}
+
+	if err := auth.refreshKeys(); err != nil {
+		// A failed initial fetch is not fatal: the static key (if any)
+		// may still be usable, and the refresh loop will keep retrying.
+		s.Warnf("failed initial JWKS refresh: %s", err.Error())
+	}
+
+	if len(auth.issuers) > 0 || len(auth.jwksURIs) > 0 {
+		go auth.refreshLoop()
+	}
+
 	return auth, nil
 }
 
+func splitAndTrim(raw string) []string {
+	var out []string
+	for _, p := range strings.Split(raw, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// readPublicKey loads the legacy single-key PEM configuration. Unlike the
+// JWKS paths, this has no kid to index by, so it keys the verifier under
+// its SSH fingerprint. It verifies only tokens whose alg belongs to the
+// family of its key type (RS, ES or EdDSA), like every other verifierKey.
 func (bearer *BearerAuth) readPublicKey() error {
 	jwtPublicKeyPEM := strings.Replace(os.Getenv("JWT_SIGNER_PUBLIC_KEY"), `\n`, "\n", -1)
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(jwtPublicKeyPEM))
+	publicKey, alg, err := parsePublicKeyPEM([]byte(jwtPublicKeyPEM))
 	if err != nil {
 		return err
 	}
@@ -43,77 +231,587 @@ func (bearer *BearerAuth) readPublicKey() error {
 	}
 
 	fingerprint := ssh.FingerprintLegacyMD5(sshPublicKey)
-	bearer.jwks[fingerprint] = publicKey
+
+	bearer.mu.Lock()
+	bearer.jwks[fingerprint] = &verifierKey{key: publicKey, alg: alg}
+	bearer.mu.Unlock()
 
 	return nil
 }
 
+// parsePublicKeyPEM accepts an RSA, ECDSA or Ed25519 public key in PEM
+// form and returns the decoded key along with the JWT alg family it is
+// valid for ("RS", "ES" or "EdDSA").
+func parsePublicKeyPEM(pemBytes []byte) (crypto.PublicKey, string, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, "", fmt.Errorf("failed to decode PEM block containing public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		// Fall back to the PKCS1 RSA form accepted by older configs.
+		if rsaKey, rsaErr := x509.ParsePKCS1PublicKey(block.Bytes); rsaErr == nil {
+			return rsaKey, "RS", nil
+		}
+		return nil, "", err
+	}
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return pub, "RS", nil
+	case *ecdsa.PublicKey:
+		return pub, "ES", nil
+	case ed25519.PublicKey:
+		return pub, "EdDSA", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// refreshLoop periodically re-runs discovery/JWKS fetches so that keys
+// rotated by the IdP show up without requiring a server restart. The
+// timer (rather than a fixed ticker) is re-armed from
+// currentRefreshInterval on every cycle so that a JWKS response's
+// Cache-Control max-age can change the schedule on the fly.
+func (bearer *BearerAuth) refreshLoop() {
+	timer := time.NewTimer(bearer.currentRefreshInterval())
+	defer timer.Stop()
+	for range timer.C {
+		if err := bearer.refreshKeys(); err != nil {
+			bearer.server.Warnf("failed to refresh JWKS: %s", err.Error())
+		}
+		timer.Reset(bearer.currentRefreshInterval())
+	}
+}
+
+// currentRefreshInterval returns the interval the refresh loop should
+// next wait for, as possibly replaced by setRefreshInterval.
+func (bearer *BearerAuth) currentRefreshInterval() time.Duration {
+	bearer.mu.RLock()
+	defer bearer.mu.RUnlock()
+	return bearer.refreshInterval
+}
+
+// setRefreshInterval honors a JWKS response's Cache-Control max-age by
+// adopting it as the new refresh interval, so we don't hold a rotated-out
+// key past the point the IdP told us it would stop being valid.
+func (bearer *BearerAuth) setRefreshInterval(d time.Duration) {
+	bearer.mu.Lock()
+	defer bearer.mu.Unlock()
+	bearer.refreshInterval = d
+}
+
+// refreshKeys resolves every configured issuer (via OIDC discovery) and
+// every explicitly configured JWKS URI, merging the resulting keys into
+// bearer.jwks keyed by their JWT `kid`.
+func (bearer *BearerAuth) refreshKeys() error {
+	var errs []string
+
+	for _, issuer := range bearer.issuers {
+		uri, err := bearer.discoverJWKSURI(issuer)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := bearer.fetchJWKS(uri, issuer); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	for _, uri := range bearer.jwksURIs {
+		if err := bearer.fetchJWKS(uri, ""); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// discoverJWKSURI fetches the OIDC discovery document for issuer and
+// returns the jwks_uri it advertises.
+func (bearer *BearerAuth) discoverJWKSURI(issuer string) (string, error) {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := bearer.httpClient.Get(discoveryURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch OIDC discovery document for %s: %s", issuer, err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read OIDC discovery document for %s: %s", issuer, err.Error())
+	}
+
+	var doc oidcDiscoveryDoc
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("failed to decode OIDC discovery document for %s: %s", issuer, err.Error())
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("OIDC discovery document for %s has no jwks_uri", issuer)
+	}
+	return doc.JWKSURI, nil
+}
+
+// fetchJWKS downloads and parses a JWKS document, indexing every key it
+// contains by its `kid` and binding it to issuer (empty for JWKS URIs
+// configured directly, without a discovered issuer). A Cache-Control
+// max-age on the response, if present, becomes the new refresh interval.
+func (bearer *BearerAuth) fetchJWKS(uri, issuer string) error {
+	resp, err := bearer.httpClient.Get(uri)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS %s: %s", uri, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if maxAge, ok := parseCacheControlMaxAge(resp.Header.Get("Cache-Control")); ok {
+		bearer.setRefreshInterval(maxAge)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS %s: %s", uri, err.Error())
+	}
+
+	var doc jwksDoc
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("failed to decode JWKS %s: %s", uri, err.Error())
+	}
+
+	keys := map[string]*verifierKey{}
+	for _, k := range doc.Keys {
+		if k.Kid == "" {
+			continue
+		}
+		vk, err := verifierKeyFromJWK(k)
+		if err != nil {
+			bearer.server.Warnf("skipping malformed JWKS key %q from %s: %s", k.Kid, uri, err.Error())
+			continue
+		}
+		vk.issuer = issuer
+		keys[k.Kid] = vk
+	}
+
+	bearer.mu.Lock()
+	for kid, vk := range keys {
+		bearer.jwks[kid] = vk
+	}
+	bearer.mu.Unlock()
+
+	return nil
+}
+
+// parseCacheControlMaxAge extracts the max-age directive (in seconds)
+// from a Cache-Control header value, if present.
+func parseCacheControlMaxAge(header string) (time.Duration, bool) {
+	for _, directive := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+		if len(parts) != 2 || !strings.EqualFold(strings.TrimSpace(parts[0]), "max-age") {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || secs <= 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	return 0, false
+}
+
+// verifierKeyFromJWK reconstructs a verifierKey from a single JWKS entry,
+// supporting the RSA ("RSA"), ECDSA ("EC") and Ed25519 ("OKP") key types.
+func verifierKeyFromJWK(k jwk) (*verifierKey, error) {
+	switch k.Kty {
+	case "RSA":
+		pub, err := rsaPublicKeyFromJWK(k)
+		if err != nil {
+			return nil, err
+		}
+		return &verifierKey{key: pub, alg: "RS"}, nil
+	case "EC":
+		pub, err := ecdsaPublicKeyFromJWK(k)
+		if err != nil {
+			return nil, err
+		}
+		return &verifierKey{key: pub, alg: "ES"}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve: %s", k.Crv)
+		}
+		pub, err := ed25519PublicKeyFromJWK(k)
+		if err != nil {
+			return nil, err
+		}
+		return &verifierKey{key: pub, alg: "EdDSA"}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+// rsaPublicKeyFromJWK reconstructs an *rsa.PublicKey from the base64url
+// encoded modulus/exponent carried by a JWK.
+func rsaPublicKeyFromJWK(k jwk) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %s", err.Error())
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %s", err.Error())
+	}
+
+	e := 0
+	for _, b := range eBytes {
+		e = e<<8 | int(b)
+	}
+	if e == 0 {
+		return nil, fmt.Errorf("invalid exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: e,
+	}, nil
+}
+
+// ecdsaPublicKeyFromJWK reconstructs an *ecdsa.PublicKey from the
+// base64url encoded coordinates carried by a JWK.
+func ecdsaPublicKeyFromJWK(k jwk) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported EC curve: %s", k.Crv)
+	}
+
+	xBytes, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %s", err.Error())
+	}
+	yBytes, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %s", err.Error())
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(xBytes),
+		Y:     new(big.Int).SetBytes(yBytes),
+	}, nil
+}
+
+// ed25519PublicKeyFromJWK reconstructs an ed25519.PublicKey from the
+// base64url encoded "x" value carried by an OKP JWK.
+func ed25519PublicKeyFromJWK(k jwk) (ed25519.PublicKey, error) {
+	xBytes, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x value: %s", err.Error())
+	}
+	if len(xBytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key length: %d", len(xBytes))
+	}
+	return ed25519.PublicKey(xBytes), nil
+}
+
+func (bearer *BearerAuth) lookupKey(kid string) *verifierKey {
+	bearer.mu.RLock()
+	defer bearer.mu.RUnlock()
+	return bearer.jwks[kid]
+}
+
+// lookupKeyOrRefresh looks up kid, and if it isn't known yet, triggers a
+// synchronous on-demand JWKS refresh before giving up — a key rotated by
+// the IdP in between scheduled refreshes shouldn't lock clients out for
+// up to refreshInterval. Repeated lookups for a kid that's still unknown
+// after a refresh are remembered in negCache so they don't each trigger
+// their own refresh.
+func (bearer *BearerAuth) lookupKeyOrRefresh(kid string) *verifierKey {
+	if vk := bearer.lookupKey(kid); vk != nil {
+		return vk
+	}
+	if len(bearer.issuers) == 0 && len(bearer.jwksURIs) == 0 {
+		// Nothing to refresh from (e.g. the legacy static key only).
+		return nil
+	}
+
+	bearer.mu.Lock()
+	if negAt, ok := bearer.negCache[kid]; ok && time.Since(negAt) < negativeCacheTTL {
+		bearer.mu.Unlock()
+		return nil
+	}
+	if time.Since(bearer.lastForcedRefresh) < minForcedRefreshInterval {
+		bearer.mu.Unlock()
+		// A refresh triggered by a concurrent lookup may have just
+		// landed; check once more before giving up.
+		return bearer.lookupKey(kid)
+	}
+	bearer.lastForcedRefresh = time.Now()
+	bearer.mu.Unlock()
+
+	if err := bearer.refreshKeys(); err != nil {
+		bearer.server.Warnf("on-demand JWKS refresh for kid %q failed: %s", kid, err.Error())
+	}
+
+	if vk := bearer.lookupKey(kid); vk != nil {
+		return vk
+	}
+
+	bearer.mu.Lock()
+	bearer.negCache[kid] = time.Now()
+	bearer.mu.Unlock()
+	return nil
+}
+
+// ed25519SigningMethod adapts crypto/ed25519 to the jwt.SigningMethod
+// interface used by dgrijalva/jwt-go, which has no native EdDSA support.
+// BearerAuth itself only verifies tokens; Sign takes an
+// ed25519.PrivateKey.
+type ed25519SigningMethod struct{}
+
+func (m *ed25519SigningMethod) Alg() string {
+	return "EdDSA"
+}
+
+func (m *ed25519SigningMethod) Verify(signingString, signature string, key interface{}) error {
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("EdDSA verify expects ed25519.PublicKey")
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return fmt.Errorf("EdDSA signature verification failed")
+	}
+	return nil
+}
+
+func (m *ed25519SigningMethod) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("EdDSA sign expects ed25519.PrivateKey")
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+func init() {
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod {
+		return &ed25519SigningMethod{}
+	})
+}
+
+// signingMethodAlg maps a jwt.SigningMethod to the alg family used to key
+// verifierKey, so the keyfunc can reject a key resolved for one algorithm
+// being used to verify a token signed with another (alg confusion).
+func signingMethodAlg(method jwt.SigningMethod) (string, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return "RS", nil
+	case *jwt.SigningMethodECDSA:
+		return "ES", nil
+	case *ed25519SigningMethod:
+		return "EdDSA", nil
+	default:
+		return "", fmt.Errorf("unexpected signing alg: %s", method.Alg())
+	}
+}
+
+// validateIssuer enforces that a token's `iss` claim is consistent with
+// where vk was obtained from. A key bound to a specific issuer (i.e.
+// fetched via OIDC discovery) must only verify tokens claiming that same
+// issuer, so that a kid collision across issuers can't be used to make a
+// token from issuer B pass itself off as issuer A. Keys with no bound
+// issuer (the legacy static key, or a JWKS URI configured without
+// discovery) fall back to checking `iss` against the configured issuer
+// allow-list, if any is configured.
+func (bearer *BearerAuth) validateIssuer(vk *verifierKey, claims jwt.MapClaims) error {
+	iss, _ := claims["iss"].(string)
+
+	if vk.issuer != "" {
+		if iss != vk.issuer {
+			return fmt.Errorf("token iss %q does not match the issuer %q its signing key was fetched from", iss, vk.issuer)
+		}
+		return nil
+	}
+
+	if len(bearer.issuers) == 0 {
+		return nil
+	}
+	for _, allowed := range bearer.issuers {
+		if iss == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("token iss %q is not in the configured issuer list", iss)
+}
+
+// validateAudience enforces that a token's `aud` claim (a single string
+// or an array of strings) intersects with the configured audience
+// allow-list, when one is configured via BearerAuthOptions.Audiences.
+func (bearer *BearerAuth) validateAudience(claims jwt.MapClaims) error {
+	if len(bearer.audiences) == 0 {
+		return nil
+	}
+
+	var auds []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		auds = []string{aud}
+	case []interface{}:
+		for _, v := range aud {
+			if s, ok := v.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+	}
+
+	for _, a := range auds {
+		for _, allowed := range bearer.audiences {
+			if a == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("token aud %v does not include an allowed audience", auds)
+}
+
 func (bearer *BearerAuth) Check(c ClientAuthentication) bool {
+	event := AuthEvent{
+		AuthMethod: "bearer",
+		Decision:   AuthDecisionDeny,
+	}
+	if addr := c.RemoteAddress(); addr != nil {
+		event.RemoteAddr = addr.String()
+	}
+	if cl, clOk := c.(*client); clOk {
+		event.ClientID = cl.cid
+	}
+	allow := false
+	defer func() {
+		if allow {
+			event.Decision = AuthDecisionAllow
+		}
+		bearer.auditor.Record(context.Background(), event)
+	}()
+
 	bearerToken := c.GetOpts().JWT
 	jwtToken, err := jwt.Parse(bearerToken, func(_jwtToken *jwt.Token) (interface{}, error) {
-		if _, ok := _jwtToken.Method.(*jwt.SigningMethodRSA); !ok { // FIXME-- also support ed25519 spec
-			return nil, fmt.Errorf("failed to parse bearer authorization; unexpected signing alg: %s", _jwtToken.Method.Alg())
+		wantAlg, err := signingMethodAlg(_jwtToken.Method)
+		if err != nil {
+			return nil, err
 		}
 
-		var publicKey *rsa.PublicKey
-
 		var kid *string
 		if kidhdr, ok := _jwtToken.Header["kid"].(string); ok {
 			kid = &kidhdr
 		}
-
-		if kid != nil {
-			publicKey = bearer.jwks[*kid]
+		if kid == nil {
+			return nil, fmt.Errorf("bearer token has no kid header")
 		}
+		event.TokenKid = *kid
 
-		if publicKey == nil {
+		vk := bearer.lookupKeyOrRefresh(*kid)
+		if vk == nil {
 			return nil, fmt.Errorf("failed to resolve verifier for kid: %s", *kid)
 		}
+		if vk.alg != wantAlg {
+			return nil, fmt.Errorf("kid %s is not valid for alg %s", *kid, _jwtToken.Method.Alg())
+		}
+
+		if claims, ok := _jwtToken.Claims.(jwt.MapClaims); ok {
+			if iss, ok := claims["iss"].(string); ok {
+				event.Issuer = iss
+			}
+			if err := bearer.validateIssuer(vk, claims); err != nil {
+				return nil, err
+			}
+			if err := bearer.validateAudience(claims); err != nil {
+				return nil, err
+			}
+		}
 
-		return publicKey, nil
+		return vk.key, nil
 	})
 
 	if err != nil {
-		bearer.server.Tracef(fmt.Sprintf("failed to parse bearer authorization; %s", err.Error()))
+		event.Reason = fmt.Sprintf("failed to parse bearer authorization; %s", err.Error())
+		bearer.server.Tracef(event.Reason)
 		return false
 	}
 
 	bearer.server.Debugf(fmt.Sprintf("parsed bearer authorization: %s\n; client authentication: %s", jwtToken.Claims, c))
 	claims, claimsOk := jwtToken.Claims.(jwt.MapClaims)
 	if !claimsOk {
-		bearer.server.Warnf(fmt.Sprintf("no claims present in verified JWT; %s", err.Error()))
+		event.Reason = fmt.Sprintf("no claims present in verified JWT; %s", err.Error())
+		bearer.server.Warnf(event.Reason)
 		return false
 	}
 
-	permissions := &Permissions{}
-	if permissionsClaim, permissionsClaimOk := claims["permissions"].(map[string]interface{}); permissionsClaimOk {
-		if _, pubOk := permissionsClaim["publish"]; !pubOk {
-			permissionsClaim["publish"] = map[string]interface{}{
-				"allow": []string{},
-				"deny":  []string{},
-			}
+	if sub, ok := claims["sub"].(string); ok {
+		event.SubjectClaim = sub
+	}
+	if iss, ok := claims["iss"].(string); ok {
+		event.Issuer = iss
+	}
+
+	captures := bearer.resolveCaptures(claims)
+
+	permissionsClaim, permissionsClaimOk := claims["permissions"].(map[string]interface{})
+	if !permissionsClaimOk {
+		if len(bearer.allowTemplates) == 0 && len(bearer.denyTemplates) == 0 {
+			event.Reason = fmt.Sprintf("no permissions claim present in verified JWT; %s", bearerToken)
+			bearer.server.Warnf(event.Reason)
+			return false
 		}
-		if _, subOk := permissionsClaim["subscribe"]; !subOk {
-			permissionsClaim["subscribe"] = map[string]interface{}{
-				"allow": []string{},
-				"deny":  []string{},
-			}
+		permissionsClaim = map[string]interface{}{}
+	}
+
+	if _, pubOk := permissionsClaim["publish"]; !pubOk {
+		permissionsClaim["publish"] = map[string]interface{}{
+			"allow": []string{},
+			"deny":  []string{},
 		}
-		if _, respOk := permissionsClaim["responses"]; !respOk {
-			permissionsClaim["responses"] = map[string]interface{}{
-				"max": DEFAULT_ALLOW_RESPONSE_MAX_MSGS,
-				"ttl": DEFAULT_ALLOW_RESPONSE_EXPIRATION,
-			}
+	}
+	if _, subOk := permissionsClaim["subscribe"]; !subOk {
+		permissionsClaim["subscribe"] = map[string]interface{}{
+			"allow": []string{},
+			"deny":  []string{},
+		}
+	}
+	if _, respOk := permissionsClaim["responses"]; !respOk {
+		permissionsClaim["responses"] = map[string]interface{}{
+			"max": DEFAULT_ALLOW_RESPONSE_MAX_MSGS,
+			"ttl": DEFAULT_ALLOW_RESPONSE_EXPIRATION,
 		}
-		permissionsRaw, _ := json.Marshal(permissionsClaim)
-		json.Unmarshal(permissionsRaw, &permissions) // HACK
-	} else {
-		bearer.server.Warnf(fmt.Sprintf("no permissions claim present in verified JWT; %s", bearerToken))
+	}
+	bearer.applySubjectTemplates(permissionsClaim, captures)
+
+	permissions := &Permissions{}
+	permissionsRaw, _ := json.Marshal(permissionsClaim)
+	json.Unmarshal(permissionsRaw, &permissions) // HACK
+
+	account, err := bearer.resolveAccount(captures)
+	if err != nil {
+		event.Reason = fmt.Sprintf("failed to resolve account for bearer authorization: %s", err.Error())
+		bearer.server.Warnf(event.Reason)
 		return false
 	}
+	if account != nil {
+		event.MatchedAccount = account.GetName()
+	}
 
 	bearer.server.Tracef("registering ephemeral user with permissions: %s", permissions)
 	c.RegisterUser(&User{
+		Account:     account,
 		Permissions: permissions,
 	})
 
@@ -125,12 +823,14 @@ func (bearer *BearerAuth) Check(c ClientAuthentication) bool {
 		case json.Number:
 			exp, _ = expClaim.Int64()
 		default:
-			bearer.server.Tracef("failed to parse bearer authorization expiration")
+			event.Reason = "failed to parse bearer authorization expiration"
+			bearer.server.Tracef(event.Reason)
 			return false
 		}
 
 		now := time.Now().Unix()
 		if now >= exp {
+			event.Reason = "bearer token expired"
 			return false
 		}
 
@@ -139,5 +839,6 @@ func (bearer *BearerAuth) Check(c ClientAuthentication) bool {
 			Expires: exp,
 		}, 0)
 	}
+	allow = true
 	return true
 }
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// reservedAccountNames can never be the target of a claim-synthesized
+// account: $G is the implicit global account and $SYS/$SYSTEM are
+// reserved for the server's own system account. Without this check a
+// claim value of e.g. "$SYS" in an unconstrained template like
+// "{tenant_id}" would let a bearer token register itself into the
+// system account.
+var reservedAccountNames = map[string]bool{
+	"$G":      true,
+	"$SYS":    true,
+	"$SYSTEM": true,
+}
+
+// subjectSpecialChars are the characters that give a NATS subject
+// wildcard or separator meaning. A claim capture that contains one of
+// these could widen a subject template like "tenants.{tenant_id}.>"
+// into a pattern that escapes the tenant's own namespace, so captures
+// are validated against this before they're spliced into any template.
+const subjectSpecialChars = ".*>"
+
+// maxSynthesizedAccounts bounds how many accounts resolveAccount will
+// create on the fly from claim values, so a stream of tokens carrying
+// distinct (attacker-influenced) tenant claims can't be used to grow
+// the server's account table without bound.
+const maxSynthesizedAccounts = 10000
+
+// claimMapping extracts named captures out of a single JWT claim so they
+// can be used to resolve an account and expand subject templates. path
+// addresses the claim using dot notation (e.g. "resource.tenant_id") to
+// reach into nested claim objects; pattern is matched against the claim's
+// string value (or, for array claims such as "groups", against each
+// element in turn) and contributes its named capture groups.
+type claimMapping struct {
+	path    string
+	pattern *regexp.Regexp
+}
+
+// claimMappingConfig is the JSON shape read from JWT_CLAIM_MAPPINGS.
+type claimMappingConfig struct {
+	Claim   string `json:"claim"`
+	Pattern string `json:"pattern"`
+}
+
+// configureClaimMapping reads the claim-to-account/subject mapping layer
+// from the environment. All of it is optional: a deployment that only
+// wants bearer authentication without multitenancy can leave it unset and
+// Check will behave exactly as before.
+func (bearer *BearerAuth) configureClaimMapping() error {
+	if raw := os.Getenv("JWT_CLAIM_MAPPINGS"); raw != "" {
+		var cfg []claimMappingConfig
+		if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+			return fmt.Errorf("failed to parse JWT_CLAIM_MAPPINGS: %s", err.Error())
+		}
+		for _, c := range cfg {
+			re, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern for claim %q: %s", c.Claim, err.Error())
+			}
+			bearer.claimMappings = append(bearer.claimMappings, claimMapping{path: c.Claim, pattern: re})
+		}
+	}
+
+	bearer.accountTemplate = os.Getenv("JWT_ACCOUNT_TEMPLATE")
+	if raw := os.Getenv("JWT_SUBJECT_ALLOW_TEMPLATES"); raw != "" {
+		bearer.allowTemplates = splitAndTrim(raw)
+	}
+	if raw := os.Getenv("JWT_SUBJECT_DENY_TEMPLATES"); raw != "" {
+		bearer.denyTemplates = splitAndTrim(raw)
+	}
+	return nil
+}
+
+// resolveCaptures runs every configured claimMapping against claims and
+// merges their named capture groups into a single map. Later mappings
+// win on key collision. A capture containing a NATS subject wildcard or
+// separator character is dropped rather than merged: captures are later
+// spliced verbatim into both account names and subject templates, and
+// admitting one of these characters would let a crafted claim value
+// widen a subject template like "tenants.{tenant_id}.>" into a pattern
+// that escapes the tenant's own namespace.
+func (bearer *BearerAuth) resolveCaptures(claims jwt.MapClaims) map[string]string {
+	captures := map[string]string{}
+	for _, m := range bearer.claimMappings {
+		for _, value := range claimStringValues(claims, m.path) {
+			match := m.pattern.FindStringSubmatch(value)
+			if match == nil {
+				continue
+			}
+			for i, name := range m.pattern.SubexpNames() {
+				if i == 0 || name == "" {
+					continue
+				}
+				if strings.ContainsAny(match[i], subjectSpecialChars) {
+					continue
+				}
+				captures[name] = match[i]
+			}
+		}
+	}
+	return captures
+}
+
+// claimStringValues resolves a dot-notation claim path against claims and
+// returns its value(s) as strings. A string (or fmt.Stringer) value
+// yields a single element; arrays (e.g. "groups") yield one element per
+// string entry, skipping non-string entries. Any other value, such as a
+// number or a bool, yields nil.
+func claimStringValues(claims jwt.MapClaims, path string) []string {
+	var cur interface{} = map[string]interface{}(claims)
+	for _, segment := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur, ok = m[segment]
+		if !ok {
+			return nil
+		}
+	}
+
+	switch v := cur.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, elem := range v {
+			if s, ok := elem.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case fmt.Stringer:
+		return []string{v.String()}
+	default:
+		return nil
+	}
+}
+
+// incSynthesizedAccounts increments and returns bearer's count of
+// claim-synthesized accounts, guarded by the same mutex that protects
+// the rest of BearerAuth's mutable state.
+func (bearer *BearerAuth) incSynthesizedAccounts() int {
+	bearer.mu.Lock()
+	defer bearer.mu.Unlock()
+	bearer.synthesizedAccounts++
+	return bearer.synthesizedAccounts
+}
+
+// expandTemplate replaces every "{name}" placeholder in tmpl with its
+// value from captures, e.g. "tenants.{tenant_id}.>" -> "tenants.acme.>".
+func expandTemplate(tmpl string, captures map[string]string) string {
+	args := make([]string, 0, len(captures)*2)
+	for name, value := range captures {
+		args = append(args, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(args...).Replace(tmpl)
+}
+
+// resolveAccount maps captures to a NATS account using accountTemplate
+// (e.g. "TENANT_{tenant_id}"). If the account does not already exist it
+// is synthesized and registered on first use, so claim-driven
+// multitenancy works without pre-provisioning accounts in server config.
+// A nil, nil return means no account mapping is configured and the
+// caller should leave the client on its default account.
+//
+// The expanded name is rejected outright if it names a reserved account
+// ($G, $SYS, $SYSTEM): a template with no fixed prefix (e.g.
+// "{tenant_id}") would otherwise let a token whose claim happens to be
+// one of those values land an ephemeral bearer user in the real system
+// account. Synthesizing a brand new account is further capped at
+// maxSynthesizedAccounts so a stream of tokens carrying distinct claim
+// values can't grow the account table without bound.
+func (bearer *BearerAuth) resolveAccount(captures map[string]string) (*Account, error) {
+	if bearer.accountTemplate == "" {
+		return nil, nil
+	}
+
+	name := expandTemplate(bearer.accountTemplate, captures)
+	if strings.Contains(name, "{") {
+		return nil, fmt.Errorf("account template %q left unresolved placeholders for captures %v", bearer.accountTemplate, captures)
+	}
+	if reservedAccountNames[name] {
+		return nil, fmt.Errorf("account template %q resolved to the reserved account name %q", bearer.accountTemplate, name)
+	}
+
+	if acc, err := bearer.server.LookupAccount(name); err == nil && acc != nil {
+		return acc, nil
+	}
+
+	if n := bearer.incSynthesizedAccounts(); n > maxSynthesizedAccounts {
+		return nil, fmt.Errorf("refusing to synthesize account %q: maxSynthesizedAccounts (%d) reached", name, maxSynthesizedAccounts)
+	}
+
+	acc := NewAccount(name)
+	if err := bearer.server.RegisterAccount(acc); err != nil {
+		// Another connection may have registered it concurrently.
+		if existing, lookupErr := bearer.server.LookupAccount(name); lookupErr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, fmt.Errorf("failed to register synthesized account %q: %s", name, err.Error())
+	}
+	return acc, nil
+}
+
+// applySubjectTemplates expands the configured allow/deny subject
+// templates against captures and merges them into the raw permissions
+// claim map (the same shape later unmarshaled into *Permissions),
+// alongside whatever publish/subscribe entries the token already
+// carried.
+func (bearer *BearerAuth) applySubjectTemplates(permissionsClaim map[string]interface{}, captures map[string]string) {
+	if len(bearer.allowTemplates) == 0 && len(bearer.denyTemplates) == 0 {
+		return
+	}
+
+	allow := make([]string, len(bearer.allowTemplates))
+	for i, t := range bearer.allowTemplates {
+		allow[i] = expandTemplate(t, captures)
+	}
+	deny := make([]string, len(bearer.denyTemplates))
+	for i, t := range bearer.denyTemplates {
+		deny[i] = expandTemplate(t, captures)
+	}
+
+	for _, field := range []string{"publish", "subscribe"} {
+		sub, _ := permissionsClaim[field].(map[string]interface{})
+		if sub == nil {
+			sub = map[string]interface{}{}
+			permissionsClaim[field] = sub
+		}
+		sub["allow"] = mergeSubjectLists(sub["allow"], allow)
+		sub["deny"] = mergeSubjectLists(sub["deny"], deny)
+	}
+}
+
+// mergeSubjectLists appends additional to whatever subject list (if any)
+// is already present in existing.
+func mergeSubjectLists(existing interface{}, additional []string) []string {
+	var out []string
+	if raw, ok := existing.([]string); ok {
+		out = append(out, raw...)
+	} else if raw, ok := existing.([]interface{}); ok {
+		for _, v := range raw {
+			if s, ok := v.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return append(out, additional...)
+}
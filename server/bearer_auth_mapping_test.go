@@ -0,0 +1,171 @@
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func mustCompile(t *testing.T, pattern string) *regexp.Regexp {
+	t.Helper()
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatalf("failed to compile %q: %s", pattern, err)
+	}
+	return re
+}
+
+func TestResolveCaptures_ExtractionAndMergeOrder(t *testing.T) {
+	bearer := &BearerAuth{
+		claimMappings: []claimMapping{
+			{path: "resource.tenant", pattern: mustCompile(t, `^(?P<tenant_id>\w+)$`)},
+			{path: "groups", pattern: mustCompile(t, `^team-(?P<team>\w+)$`)},
+			// A later mapping targeting the same capture name should win.
+			{path: "override", pattern: mustCompile(t, `^(?P<team>\w+)$`)},
+		},
+	}
+	claims := jwt.MapClaims{
+		"resource": map[string]interface{}{"tenant": "acme"},
+		"groups":   []interface{}{"team-payments", "not-a-team"},
+		"override": "platform",
+	}
+
+	captures := bearer.resolveCaptures(claims)
+	if captures["tenant_id"] != "acme" {
+		t.Fatalf("expected tenant_id=acme, got %q", captures["tenant_id"])
+	}
+	if captures["team"] != "platform" {
+		t.Fatalf("expected the later mapping to win for duplicate capture name, got %q", captures["team"])
+	}
+}
+
+func TestResolveCaptures_RejectsWildcardAndSeparatorCaptures(t *testing.T) {
+	bearer := &BearerAuth{
+		claimMappings: []claimMapping{
+			{path: "tenant", pattern: mustCompile(t, `^(?P<tenant_id>.+)$`)},
+		},
+	}
+
+	for _, value := range []string{"tenants.evil", "a*b", "a>b"} {
+		claims := jwt.MapClaims{"tenant": value}
+		captures := bearer.resolveCaptures(claims)
+		if _, ok := captures["tenant_id"]; ok {
+			t.Fatalf("expected capture %q containing a subject special character to be dropped", value)
+		}
+	}
+
+	// A clean value should still come through.
+	captures := bearer.resolveCaptures(jwt.MapClaims{"tenant": "acme"})
+	if captures["tenant_id"] != "acme" {
+		t.Fatalf("expected tenant_id=acme, got %q", captures["tenant_id"])
+	}
+}
+
+func TestClaimStringValues(t *testing.T) {
+	claims := jwt.MapClaims{
+		"sub":      "user-1",
+		"groups":   []interface{}{"a", "b", 5},
+		"resource": map[string]interface{}{"tenant": "acme"},
+	}
+
+	if got := claimStringValues(claims, "sub"); len(got) != 1 || got[0] != "user-1" {
+		t.Fatalf("expected [user-1], got %v", got)
+	}
+	if got := claimStringValues(claims, "groups"); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected non-string array entries to be skipped, got %v", got)
+	}
+	if got := claimStringValues(claims, "resource.tenant"); len(got) != 1 || got[0] != "acme" {
+		t.Fatalf("expected [acme], got %v", got)
+	}
+	if got := claimStringValues(claims, "missing.path"); got != nil {
+		t.Fatalf("expected nil for a missing path, got %v", got)
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	captures := map[string]string{"tenant_id": "acme"}
+
+	if got := expandTemplate("tenants.{tenant_id}.>", captures); got != "tenants.acme.>" {
+		t.Fatalf("expected tenants.acme.>, got %q", got)
+	}
+	// An unresolved placeholder is left as-is; callers (resolveAccount) are
+	// responsible for rejecting that.
+	if got := expandTemplate("tenants.{unknown}.>", captures); got != "tenants.{unknown}.>" {
+		t.Fatalf("expected placeholder to survive expansion unresolved, got %q", got)
+	}
+}
+
+func TestResolveAccount_NoTemplateConfigured(t *testing.T) {
+	bearer := &BearerAuth{}
+	acc, err := bearer.resolveAccount(map[string]string{"tenant_id": "acme"})
+	if err != nil || acc != nil {
+		t.Fatalf("expected nil, nil when no account template is configured, got %v, %v", acc, err)
+	}
+}
+
+func TestResolveAccount_UnresolvedPlaceholderErrors(t *testing.T) {
+	bearer := &BearerAuth{accountTemplate: "TENANT_{tenant_id}"}
+	if _, err := bearer.resolveAccount(map[string]string{}); err == nil {
+		t.Fatalf("expected an error for an unresolved placeholder")
+	}
+}
+
+func TestResolveAccount_RejectsReservedAccountNames(t *testing.T) {
+	// The reserved-name check runs before any account lookup/registration,
+	// so this doesn't require a constructible *Server.
+	bearer := &BearerAuth{accountTemplate: "{tenant_id}"}
+	for _, reserved := range []string{"$G", "$SYS", "$SYSTEM"} {
+		if _, err := bearer.resolveAccount(map[string]string{"tenant_id": reserved}); err == nil {
+			t.Fatalf("expected claim value %q to be rejected as a reserved account name", reserved)
+		}
+	}
+}
+
+func TestApplySubjectTemplates(t *testing.T) {
+	bearer := &BearerAuth{
+		allowTemplates: []string{"tenants.{tenant_id}.>"},
+		denyTemplates:  []string{"tenants.{tenant_id}.admin.>"},
+	}
+	captures := map[string]string{"tenant_id": "acme"}
+
+	permissions := map[string]interface{}{
+		"publish": map[string]interface{}{
+			"allow": []interface{}{"existing.subject"},
+		},
+	}
+	bearer.applySubjectTemplates(permissions, captures)
+
+	pub := permissions["publish"].(map[string]interface{})
+	allow := pub["allow"].([]string)
+	if len(allow) != 2 || allow[0] != "existing.subject" || allow[1] != "tenants.acme.>" {
+		t.Fatalf("expected existing entries preserved and template appended, got %v", allow)
+	}
+
+	sub := permissions["subscribe"].(map[string]interface{})
+	deny := sub["deny"].([]string)
+	if len(deny) != 1 || deny[0] != "tenants.acme.admin.>" {
+		t.Fatalf("expected deny template expanded, got %v", deny)
+	}
+}
+
+func TestApplySubjectTemplates_NoTemplatesConfiguredIsNoop(t *testing.T) {
+	bearer := &BearerAuth{}
+	permissions := map[string]interface{}{}
+	bearer.applySubjectTemplates(permissions, map[string]string{"tenant_id": "acme"})
+	if len(permissions) != 0 {
+		t.Fatalf("expected no changes when no templates are configured, got %v", permissions)
+	}
+}
+
+func TestMergeSubjectLists(t *testing.T) {
+	if got := mergeSubjectLists([]string{"a"}, []string{"b"}); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected [a b], got %v", got)
+	}
+	if got := mergeSubjectLists([]interface{}{"a", 5}, []string{"b"}); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("expected non-string entries to be dropped, got %v", got)
+	}
+	if got := mergeSubjectLists(nil, []string{"b"}); len(got) != 1 || got[0] != "b" {
+		t.Fatalf("expected [b], got %v", got)
+	}
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// generateEd25519 generates a fresh Ed25519 key pair for signing tests.
+func generateEd25519(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	t.Helper()
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// signAndParse signs claims with privKey using method/kid, then runs the
+// result through jwt.Parse with bearer's keyfunc-equivalent (lookupKey +
+// signingMethodAlg + vk.key), mirroring what Check's closure does without
+// requiring the Server/ClientAuthentication machinery Check depends on.
+func signAndParse(t *testing.T, bearer *BearerAuth, method jwt.SigningMethod, kid string, privKey interface{}, claims jwt.MapClaims) (*jwt.Token, error) {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(privKey)
+	if err != nil {
+		t.Fatalf("failed to sign token: %s", err)
+	}
+
+	return jwt.Parse(signed, func(tok *jwt.Token) (interface{}, error) {
+		wantAlg, err := signingMethodAlg(tok.Method)
+		if err != nil {
+			return nil, err
+		}
+		vk := bearer.lookupKey(kid)
+		if vk == nil {
+			return nil, jwt.NewValidationError("unknown kid", jwt.ValidationErrorUnverifiable)
+		}
+		if vk.alg != wantAlg {
+			return nil, jwt.NewValidationError("alg mismatch", jwt.ValidationErrorUnverifiable)
+		}
+		if mapClaims, ok := tok.Claims.(jwt.MapClaims); ok {
+			if err := bearer.validateIssuer(vk, mapClaims); err != nil {
+				return nil, err
+			}
+			if err := bearer.validateAudience(mapClaims); err != nil {
+				return nil, err
+			}
+		}
+		return vk.key, nil
+	})
+}
+
+func base64URLUint(n int) string {
+	b := big.NewInt(int64(n)).Bytes()
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestBearerAuth_RSASigningEndToEnd(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+
+	k := jwk{
+		Kty: "RSA",
+		Kid: "rsa-1",
+		N:   base64.RawURLEncoding.EncodeToString(priv.PublicKey.N.Bytes()),
+		E:   base64URLUint(priv.PublicKey.E),
+	}
+	vk, err := verifierKeyFromJWK(k)
+	if err != nil {
+		t.Fatalf("verifierKeyFromJWK: %s", err)
+	}
+	vk.issuer = "https://idp.example.com"
+
+	bearer := &BearerAuth{jwks: map[string]*verifierKey{"rsa-1": vk}, issuers: []string{"https://idp.example.com"}}
+
+	claims := jwt.MapClaims{"iss": "https://idp.example.com", "sub": "user-1"}
+	tok, err := signAndParse(t, bearer, jwt.SigningMethodRS256, "rsa-1", priv, claims)
+	if err != nil {
+		t.Fatalf("expected valid RSA-signed token, got error: %s", err)
+	}
+	if !tok.Valid {
+		t.Fatalf("expected token to be valid")
+	}
+}
+
+func TestBearerAuth_ECDSASigningEndToEnd(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %s", err)
+	}
+
+	k := jwk{
+		Kty: "EC",
+		Kid: "ec-1",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(priv.PublicKey.X.Bytes()),
+		Y:   base64.RawURLEncoding.EncodeToString(priv.PublicKey.Y.Bytes()),
+	}
+	vk, err := verifierKeyFromJWK(k)
+	if err != nil {
+		t.Fatalf("verifierKeyFromJWK: %s", err)
+	}
+
+	bearer := &BearerAuth{jwks: map[string]*verifierKey{"ec-1": vk}}
+
+	claims := jwt.MapClaims{"sub": "user-2"}
+	tok, err := signAndParse(t, bearer, jwt.SigningMethodES256, "ec-1", priv, claims)
+	if err != nil {
+		t.Fatalf("expected valid ECDSA-signed token, got error: %s", err)
+	}
+	if !tok.Valid {
+		t.Fatalf("expected token to be valid")
+	}
+}
+
+func TestBearerAuth_Ed25519SigningEndToEnd(t *testing.T) {
+	pub, priv, err := generateEd25519(t)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %s", err)
+	}
+
+	k := jwk{
+		Kty: "OKP",
+		Kid: "ed-1",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(pub),
+	}
+	vk, err := verifierKeyFromJWK(k)
+	if err != nil {
+		t.Fatalf("verifierKeyFromJWK: %s", err)
+	}
+
+	bearer := &BearerAuth{jwks: map[string]*verifierKey{"ed-1": vk}}
+
+	claims := jwt.MapClaims{"sub": "user-3"}
+	tok, err := signAndParse(t, bearer, &ed25519SigningMethod{}, "ed-1", priv, claims)
+	if err != nil {
+		t.Fatalf("expected valid Ed25519-signed token, got error: %s", err)
+	}
+	if !tok.Valid {
+		t.Fatalf("expected token to be valid")
+	}
+}
+
+func TestBearerAuth_AlgConfusionRejected(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+
+	k := jwk{
+		Kty: "RSA",
+		Kid: "rsa-confused",
+		N:   base64.RawURLEncoding.EncodeToString(priv.PublicKey.N.Bytes()),
+		E:   base64URLUint(priv.PublicKey.E),
+	}
+	vk, err := verifierKeyFromJWK(k)
+	if err != nil {
+		t.Fatalf("verifierKeyFromJWK: %s", err)
+	}
+
+	bearer := &BearerAuth{jwks: map[string]*verifierKey{"rsa-confused": vk}}
+
+	// Sign with HS256 using the RSA modulus bytes as an HMAC secret, as an
+	// attacker would if trying to exploit alg confusion against a
+	// key registered for RS256 verification.
+	claims := jwt.MapClaims{"sub": "attacker"}
+	_, err = signAndParse(t, bearer, jwt.SigningMethodHS256, "rsa-confused", priv.PublicKey.N.Bytes(), claims)
+	if err == nil {
+		t.Fatalf("expected alg confusion to be rejected")
+	}
+}
+
+func TestBearerAuth_ValidateIssuer(t *testing.T) {
+	bearer := &BearerAuth{issuers: []string{"https://idp-a.example.com"}}
+
+	bound := &verifierKey{issuer: "https://idp-a.example.com"}
+	if err := bearer.validateIssuer(bound, jwt.MapClaims{"iss": "https://idp-a.example.com"}); err != nil {
+		t.Fatalf("expected matching bound issuer to pass, got: %s", err)
+	}
+	if err := bearer.validateIssuer(bound, jwt.MapClaims{"iss": "https://idp-b.example.com"}); err == nil {
+		t.Fatalf("expected key bound to issuer A to reject a token claiming issuer B")
+	}
+
+	unbound := &verifierKey{}
+	if err := bearer.validateIssuer(unbound, jwt.MapClaims{"iss": "https://idp-a.example.com"}); err != nil {
+		t.Fatalf("expected unbound key to fall back to the issuer allow-list, got: %s", err)
+	}
+	if err := bearer.validateIssuer(unbound, jwt.MapClaims{"iss": "https://unknown.example.com"}); err == nil {
+		t.Fatalf("expected unbound key to reject an issuer outside the allow-list")
+	}
+}
+
+func TestBearerAuth_ValidateAudience(t *testing.T) {
+	bearer := &BearerAuth{audiences: []string{"nats"}}
+
+	if err := bearer.validateAudience(jwt.MapClaims{"aud": "nats"}); err != nil {
+		t.Fatalf("expected matching string aud to pass, got: %s", err)
+	}
+	if err := bearer.validateAudience(jwt.MapClaims{"aud": []interface{}{"other", "nats"}}); err != nil {
+		t.Fatalf("expected matching array aud to pass, got: %s", err)
+	}
+	if err := bearer.validateAudience(jwt.MapClaims{"aud": "other"}); err == nil {
+		t.Fatalf("expected non-matching aud to be rejected")
+	}
+
+	noAudConfigured := &BearerAuth{}
+	if err := noAudConfigured.validateAudience(jwt.MapClaims{"aud": "anything"}); err != nil {
+		t.Fatalf("expected no-op when no audiences are configured, got: %s", err)
+	}
+}
+
+func TestBearerAuthFactory_OptionsConfigureWithoutEnv(t *testing.T) {
+	opts := BearerAuthOptions{
+		Issuers:             []string{"http://127.0.0.1:1"},
+		Audiences:           []string{"nats"},
+		JWKSRefreshInterval: 90 * time.Second,
+	}
+
+	bearer, err := bearerAuthFactory(&Server{}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(bearer.issuers) != 1 || bearer.issuers[0] != "http://127.0.0.1:1" {
+		t.Fatalf("expected issuers from BearerAuthOptions, got %v", bearer.issuers)
+	}
+	if len(bearer.audiences) != 1 || bearer.audiences[0] != "nats" {
+		t.Fatalf("expected audiences from BearerAuthOptions, got %v", bearer.audiences)
+	}
+	if bearer.currentRefreshInterval() != 90*time.Second {
+		t.Fatalf("expected JWKSRefreshInterval to override the default, got %s", bearer.currentRefreshInterval())
+	}
+}
+
+func TestBearerAuthFactory_NoSourceConfiguredErrors(t *testing.T) {
+	if _, err := bearerAuthFactory(&Server{}, BearerAuthOptions{}); err == nil {
+		t.Fatalf("expected an error when no issuers, JWKS URIs, or static key are configured")
+	}
+}
+
+func TestBearerAuth_LookupKeyOrRefreshNegativeCache(t *testing.T) {
+	bearer := &BearerAuth{
+		jwks:       map[string]*verifierKey{},
+		negCache:   map[string]time.Time{},
+		issuers:    []string{"http://127.0.0.1:1"},
+		httpClient: &http.Client{Timeout: time.Second},
+	}
+	// discoverJWKSURI will fail (no reachable IdP), but refreshKeys should
+	// still return cleanly through lookupKeyOrRefresh, recording the kid
+	// as negatively cached rather than panicking or blocking.
+	if vk := bearer.lookupKeyOrRefresh("missing"); vk != nil {
+		t.Fatalf("expected nil for an unknown kid with no reachable JWKS source")
+	}
+	if _, ok := bearer.negCache["missing"]; !ok {
+		t.Fatalf("expected kid to be recorded in the negative cache after a failed refresh")
+	}
+}
+
+func TestParsePublicKeyPEM_AllFamilies(t *testing.T) {
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %s", err)
+	}
+	rsaDER, err := x509.MarshalPKIXPublicKey(&rsaPriv.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal RSA public key: %s", err)
+	}
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDER})
+	if _, alg, err := parsePublicKeyPEM(rsaPEM); err != nil || alg != "RS" {
+		t.Fatalf("expected RS alg for RSA PEM, got alg=%q err=%v", alg, err)
+	}
+
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %s", err)
+	}
+	ecDER, err := x509.MarshalPKIXPublicKey(&ecPriv.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal ECDSA public key: %s", err)
+	}
+	ecPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecDER})
+	if _, alg, err := parsePublicKeyPEM(ecPEM); err != nil || alg != "ES" {
+		t.Fatalf("expected ES alg for ECDSA PEM, got alg=%q err=%v", alg, err)
+	}
+
+	edPub, _, err := generateEd25519(t)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key: %s", err)
+	}
+	edDER, err := x509.MarshalPKIXPublicKey(edPub)
+	if err != nil {
+		t.Fatalf("failed to marshal Ed25519 public key: %s", err)
+	}
+	edPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edDER})
+	if _, alg, err := parsePublicKeyPEM(edPEM); err != nil || alg != "EdDSA" {
+		t.Fatalf("expected EdDSA alg for Ed25519 PEM, got alg=%q err=%v", alg, err)
+	}
+}
+
+func TestParseCacheControlMaxAge(t *testing.T) {
+	if d, ok := parseCacheControlMaxAge("max-age=120"); !ok || d != 120*time.Second {
+		t.Fatalf("expected 120s max-age, got %s ok=%v", d, ok)
+	}
+	if d, ok := parseCacheControlMaxAge("no-cache, max-age=30, must-revalidate"); !ok || d != 30*time.Second {
+		t.Fatalf("expected 30s max-age, got %s ok=%v", d, ok)
+	}
+	if _, ok := parseCacheControlMaxAge("no-cache"); ok {
+		t.Fatalf("expected no max-age to be found")
+	}
+	if _, ok := parseCacheControlMaxAge("max-age=0"); ok {
+		t.Fatalf("expected non-positive max-age to be rejected")
+	}
+}
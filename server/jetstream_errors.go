@@ -0,0 +1,330 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorsDataSource is the raw JSON error catalog, embedded at build time
+// and parsed into ApiErrors on package init. It is a var rather than a
+// const so that it can be swapped out in tests.
+//
+//go:embed errors.json
+var errorsDataSource string
+
+// ErrorIdentifier identifies a JetStream API error independently of its
+// (possibly templated) description, so callers can compare/assert on
+// error identity with IsNatsErr rather than string matching.
+type ErrorIdentifier uint16
+
+// ApiError is the error type returned by the JetStream API. Beyond the
+// legacy {code, err_code, description} response shape, it carries an
+// optional HelpURL and Tags so operators can aggregate and triage errors
+// without parsing the description text.
+type ApiError struct {
+	Code        int      `json:"code"`
+	ErrCode     uint16   `json:"err_code,omitempty"`
+	Description string   `json:"description,omitempty"`
+	HelpURL     string   `json:"help_url,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// ApiErrors holds every known JetStream error, keyed by its stable
+// ErrorIdentifier (its err_code). It is populated from errors.json at
+// package init.
+var ApiErrors map[ErrorIdentifier]*ApiError
+
+// JetStream error identifiers. Values match the err_code carried in
+// errors.json and in the wire protocol, so they must not be renumbered
+// without also updating that file.
+const (
+	JSClusterNotActiveErr        ErrorIdentifier = 10006
+	JSClusterNotAvailErr         ErrorIdentifier = 10008
+	JSNotEnabledForAccountErr    ErrorIdentifier = 10039
+	JSRestoreSubscribeFailedErrF ErrorIdentifier = 10042
+	JSStreamRestoreErrF          ErrorIdentifier = 10062
+	JSPeerRemapErr               ErrorIdentifier = 10075
+)
+
+func init() {
+	entries, err := parseErrorEntries(errorsDataSource)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse embedded JetStream errors data source: %s", err))
+	}
+	ApiErrors = make(map[ErrorIdentifier]*ApiError, len(entries))
+	for _, e := range entries {
+		ApiErrors[ErrorIdentifier(e.ErrCode)] = e
+	}
+}
+
+// ErrorsDataSource decodes the JetStream error catalog from
+// errorsDataSource. It re-parses on every call rather than caching, so
+// that tools that point errorsDataSource at an alternate catalog (or
+// tests verifying the decode-failure path) see the change immediately.
+func ErrorsDataSource() ([]*ApiError, error) {
+	return parseErrorEntries(errorsDataSource)
+}
+
+func parseErrorEntries(raw string) ([]*ApiError, error) {
+	var entries []struct {
+		Constant    string   `json:"constant"`
+		Code        int      `json:"code"`
+		ErrCode     uint16   `json:"error_code"`
+		Description string   `json:"description"`
+		Help        string   `json:"help"`
+		Tags        []string `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return nil, err
+	}
+
+	out := make([]*ApiError, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, &ApiError{
+			Code:        e.Code,
+			ErrCode:     e.ErrCode,
+			Description: e.Description,
+			HelpURL:     e.Help,
+			Tags:        e.Tags,
+		})
+	}
+	return out, nil
+}
+
+// Error implements the error interface.
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Description, e.ErrCode)
+}
+
+// MarshalJSON renders the wire shape JetStream API responses expect,
+// independent of struct field order/zero-value omission tweaks made to
+// ApiError over time.
+func (e *ApiError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code        int      `json:"code"`
+		ErrCode     uint16   `json:"err_code"`
+		Description string   `json:"description,omitempty"`
+		HelpURL     string   `json:"help_url,omitempty"`
+		Tags        []string `json:"tags,omitempty"`
+	}{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: e.Description,
+		HelpURL:     e.HelpURL,
+		Tags:        e.Tags,
+	})
+}
+
+// LogAttrs returns e's fields as a flat, alternating key/value list
+// (code, err_code, description, tags) so that a caller can log it as
+// structured attributes, e.g. on Go 1.21+:
+//
+//	slog.Error("request failed", apiErr.LogAttrs()...)
+//
+// This is deliberately untyped ([]interface{} rather than slog.Attr) so
+// that ApiError has no hard dependency on log/slog, which would otherwise
+// force every consumer of this module onto Go 1.21+ for the sake of one
+// convenience method.
+func (e *ApiError) LogAttrs() []interface{} {
+	return []interface{}{
+		"code", e.Code,
+		"err_code", e.ErrCode,
+		"description", e.Description,
+		"tags", e.Tags,
+	}
+}
+
+// ByteSize marks a NewT/NewTLang replacement value as a byte count that
+// should render in human binary units (e.g. "1.5 MiB") rather than as a raw
+// integer.
+type ByteSize int64
+
+// JSONValue marks a NewT/NewTLang replacement value that should render
+// as its compact JSON encoding, e.g. for embedding a struct or map in an
+// error description.
+type JSONValue struct {
+	V interface{}
+}
+
+// humanByteSize renders n using the same binary unit ladder as the rest
+// of the server's size formatting (KiB/MiB/...), rounded to one decimal.
+func humanByteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// toReplacerArgs turns the flat ("{placeholder}", value, ...) varargs
+// accepted by NewT into the alternating old/new pairs strings.Replacer
+// expects, formatting each value according to its type.
+//
+// replacements must have an even number of elements, alternating
+// placeholder and value; an odd-length list is a programmer error in the
+// calling code, not a condition callers can reasonably recover from, so
+// it panics (with a stack trace attached) rather than silently dropping
+// the dangling entry.
+func (e *ApiError) toReplacerArgs(replacements []interface{}) []string {
+	if len(replacements)%2 != 0 {
+		panic(fmt.Sprintf("ApiError.NewT: odd number of replacement arguments for %q\n%s", e.Description, debug.Stack()))
+	}
+
+	args := make([]string, 0, len(replacements))
+	for i := 0; i < len(replacements); i += 2 {
+		placeholder, _ := replacements[i].(string)
+		args = append(args, placeholder, formatReplacement(replacements[i+1]))
+	}
+	return args
+}
+
+// formatReplacement renders a single NewT replacement value.
+func formatReplacement(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case error:
+		return val.Error()
+	case time.Time:
+		return val.Format(time.RFC3339)
+	case ByteSize:
+		return humanByteSize(int64(val))
+	case JSONValue:
+		b, err := json.Marshal(val.V)
+		if err != nil {
+			return fmt.Sprintf("%v", val.V)
+		}
+		return string(b)
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// NewT returns a new *ApiError with replacements applied to e's
+// Description, e.g.
+//
+//	ApiErrors[JSRestoreSubscribeFailedErrF].NewT("{subject}", subj, "{err}", err)
+//
+// e itself is never mutated.
+func (e *ApiError) NewT(replacements ...interface{}) *ApiError {
+	if len(replacements) == 0 {
+		return e
+	}
+	args := e.toReplacerArgs(replacements)
+	return &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: strings.NewReplacer(args...).Replace(e.Description),
+		HelpURL:     e.HelpURL,
+		Tags:        e.Tags,
+	}
+}
+
+// NewTLang behaves like NewT but expands against the description
+// registered for lang via RegisterLocale, falling back to e's default
+// (English) description when lang has no translation for this error.
+func (e *ApiError) NewTLang(lang string, replacements ...interface{}) *ApiError {
+	localized := &ApiError{
+		Code:        e.Code,
+		ErrCode:     e.ErrCode,
+		Description: e.descriptionForLocale(lang),
+		HelpURL:     e.HelpURL,
+		Tags:        e.Tags,
+	}
+	return localized.NewT(replacements...)
+}
+
+// ErrOrNewT returns err unchanged if it is already an *ApiError,
+// otherwise wraps it by instantiating e via NewT. If err is nil, e is
+// returned as-is (with no replacements applied).
+func (e *ApiError) ErrOrNewT(err error, replacements ...interface{}) error {
+	if err == nil {
+		return e
+	}
+	if ae, ok := err.(*ApiError); ok {
+		return ae
+	}
+	return e.NewT(replacements...)
+}
+
+// ErrOrNewTLang is the locale-aware counterpart of ErrOrNewT.
+func (e *ApiError) ErrOrNewTLang(err error, lang string, replacements ...interface{}) error {
+	if err == nil {
+		return e
+	}
+	if ae, ok := err.(*ApiError); ok {
+		return ae
+	}
+	return e.NewTLang(lang, replacements...)
+}
+
+// ErrOr returns err unchanged if it is already an *ApiError, otherwise
+// returns e. If err is nil, e is returned.
+func (e *ApiError) ErrOr(err error) error {
+	if err == nil {
+		return e
+	}
+	if ae, ok := err.(*ApiError); ok {
+		return ae
+	}
+	return e
+}
+
+// IsNatsErr reports whether err is an *ApiError whose ErrCode matches any
+// of ids.
+func IsNatsErr(err error, ids ...ErrorIdentifier) bool {
+	if err == nil {
+		return false
+	}
+	ae, ok := err.(*ApiError)
+	if !ok {
+		return false
+	}
+	for _, id := range ids {
+		if uint16(id) == ae.ErrCode {
+			return true
+		}
+	}
+	return false
+}
+
+// locales holds operator-registered translations, keyed by language tag
+// (e.g. "fr", "ja") and then by err_code.
+var (
+	localesMu sync.RWMutex
+	locales   = map[string]map[uint16]string{}
+)
+
+// RegisterLocale installs (or replaces) the description translations for
+// lang, so that NewTLang/ErrOrNewTLang can localize JetStream error
+// descriptions without forking the server. translations maps an error's
+// err_code to its translated, still-templated description.
+func RegisterLocale(lang string, translations map[uint16]string) {
+	localesMu.Lock()
+	defer localesMu.Unlock()
+	locales[lang] = translations
+}
+
+// descriptionForLocale returns e's description translated into lang, or
+// its default description if lang is unregistered or has no entry for
+// this error.
+func (e *ApiError) descriptionForLocale(lang string) string {
+	localesMu.RLock()
+	defer localesMu.RUnlock()
+	if translations, ok := locales[lang]; ok {
+		if d, ok := translations[e.ErrCode]; ok {
+			return d
+		}
+	}
+	return e.Description
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
@@ -112,3 +113,93 @@ func TestApiError_NewT(t *testing.T) {
 		t.Fatalf("Expected formatted error, got: %q", ne.Description)
 	}
 }
+
+func TestApiError_NewT_BytesTimeJSON(t *testing.T) {
+	aerr := ApiError{
+		Code:        999,
+		Description: "used {bytes}, restored at {time}, details {json}",
+	}
+
+	when := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ne := aerr.NewT(
+		"{bytes}", ByteSize(1536),
+		"{time}", when,
+		"{json}", JSONValue{V: map[string]int{"n": 1}},
+	)
+	want := fmt.Sprintf("used 1.5 KiB, restored at %s, details {\"n\":1}", when.Format(time.RFC3339))
+	if ne.Description != want {
+		t.Fatalf("Expected %q, got %q", want, ne.Description)
+	}
+}
+
+func TestApiError_NewT_OddArgsPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected NewT to panic on an odd number of replacement arguments")
+		}
+	}()
+	ApiErrors[JSClusterNotActiveErr].NewT("{one}")
+}
+
+func TestApiError_MarshalJSON(t *testing.T) {
+	ae := ApiErrors[JSNotEnabledForAccountErr]
+	b, err := json.Marshal(ae)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if decoded["err_code"].(float64) != float64(ae.ErrCode) {
+		t.Fatalf("Expected err_code %v, got %v", ae.ErrCode, decoded["err_code"])
+	}
+	if decoded["description"] != ae.Description {
+		t.Fatalf("Expected description %q, got %v", ae.Description, decoded["description"])
+	}
+	if _, ok := decoded["help_url"]; ok {
+		t.Fatalf("Expected help_url to be omitted when empty")
+	}
+}
+
+func TestApiError_LogAttrs(t *testing.T) {
+	ae := ApiErrors[JSClusterNotActiveErr]
+	attrs := ae.LogAttrs()
+	if len(attrs)%2 != 0 {
+		t.Fatalf("Expected an even number of alternating key/value entries, got %d", len(attrs))
+	}
+
+	got := map[string]interface{}{}
+	for i := 0; i < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok {
+			t.Fatalf("Expected key at index %d to be a string, got %T", i, attrs[i])
+		}
+		got[key] = attrs[i+1]
+	}
+	if got["err_code"] != ae.ErrCode {
+		t.Fatalf("Expected err_code %v, got %v", ae.ErrCode, got["err_code"])
+	}
+	if got["description"] != ae.Description {
+		t.Fatalf("Expected description %q, got %v", ae.Description, got["description"])
+	}
+}
+
+func TestApiError_RegisterLocaleAndNewTLang(t *testing.T) {
+	defer RegisterLocale("fr", nil)
+
+	RegisterLocale("fr", map[uint16]string{
+		uint16(JSClusterNotActiveErr): "JetStream n'est pas en mode cluster",
+	})
+
+	ae := ApiErrors[JSClusterNotActiveErr]
+	if ne := ae.NewTLang("fr"); ne.Description != "JetStream n'est pas en mode cluster" {
+		t.Fatalf("Expected localized description, got %q", ne.Description)
+	}
+
+	// An unregistered language falls back to the default description.
+	if ne := ae.NewTLang("de"); ne.Description != ae.Description {
+		t.Fatalf("Expected fallback to default description, got %q", ne.Description)
+	}
+}